@@ -0,0 +1,513 @@
+package target
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/chef/automate/components/automate-deployment/pkg/habpkg"
+)
+
+const (
+	// defaultBuilderURL is the public Habitat Builder Depot.
+	defaultBuilderURL = "https://bldr.habitat.sh"
+
+	habArtifactCacheDir = "/hab/cache/artifacts"
+	habKeyCacheDir      = "/hab/cache/keys"
+
+	// habPkgsDir is the dedicated root a hart's payload is extracted
+	// into. Hart tar entries are rooted at "hab/pkgs/<origin>/...";
+	// that prefix is stripped before joining onto habPkgsDir so
+	// containment can be validated against habPkgsDir itself rather
+	// than against "/", where every path is trivially "contained".
+	habPkgsDir = "/hab/pkgs"
+
+	// hartPkgsPrefix is the tar-entry prefix every hart payload entry
+	// is expected to carry.
+	hartPkgsPrefix = "hab/pkgs/"
+
+	builderDownloadTimeout = 10 * time.Minute
+)
+
+// BuilderPackageIdent identifies a concrete, resolved Habitat
+// package, as returned by BuilderClient.Resolve.
+type BuilderPackageIdent struct {
+	Origin  string
+	Name    string
+	Version string
+	Release string
+}
+
+// Ident returns the fully-qualified origin/name/version/release
+// identifier for p.
+func (p BuilderPackageIdent) Ident() string {
+	return fmt.Sprintf("%s/%s/%s/%s", p.Origin, p.Name, p.Version, p.Release)
+}
+
+// BuilderClient talks directly to a Habitat Builder Depot's HTTPS
+// API, rather than shelling out to the hab CLI. It is used by
+// habCmd.InstallPackage when configured via WithBuilderClient, and
+// gives automate-deployment real progress reporting, cancellation,
+// and structured errors instead of an opaque 1200-second subprocess.
+type BuilderClient struct {
+	baseURL     string
+	authToken   string
+	httpClient  *http.Client
+	keyCacheDir string
+}
+
+// NewBuilderClient returns a BuilderClient that talks to the Builder
+// Depot at baseURL (the public Depot if baseURL is empty) using
+// authToken for authenticated operations.
+func NewBuilderClient(baseURL, authToken string) *BuilderClient {
+	if baseURL == "" {
+		baseURL = defaultBuilderURL
+	}
+	return &BuilderClient{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		authToken:   authToken,
+		httpClient:  &http.Client{Timeout: builderDownloadTimeout},
+		keyCacheDir: habKeyCacheDir,
+	}
+}
+
+// Install resolves pkg in channel, then downloads, verifies, and
+// unpacks it and every package in its TDEPS (transitive dependency
+// list), installing dependencies before the requested package so the
+// result is a complete, runnable package tree -- the same guarantee
+// `hab pkg install` provides.
+func (bc *BuilderClient) Install(pkg habpkg.Installable, channel string) error {
+	return bc.InstallCtx(context.Background(), pkg, channel, nil)
+}
+
+// InstallCtx behaves like Install, but accepts a context for
+// cancellation and emits PackageResolved, DownloadProgress, and
+// DependencyInstalled events to sink as the install progresses.
+func (bc *BuilderClient) InstallCtx(ctx context.Context, pkg habpkg.Installable, channel string, sink EventSink) error {
+	ident, tdeps, err := bc.resolveWithTDeps(ctx, pkg, channel)
+	if err != nil {
+		return err
+	}
+	emit(sink, PackageResolved{Ident: ident.Ident()})
+
+	for _, dep := range tdeps {
+		if err := bc.installIdentCtx(ctx, dep, sink); err != nil {
+			return errors.Wrapf(err, "installing dependency %s", dep.Ident())
+		}
+	}
+
+	if err := bc.installIdentCtx(ctx, ident, sink); err != nil {
+		return errors.Wrapf(err, "installing %s", ident.Ident())
+	}
+
+	return nil
+}
+
+// installIdentCtx downloads, verifies, and unpacks a single resolved
+// package, emitting DependencyInstalled once it is in place. If ident
+// is already installed under habPkgsDir, the download and unpack are
+// skipped entirely -- without this, every InstallCtx call would
+// re-pull and re-extract a package's full TDEPS tree even when most
+// of it is already on disk.
+func (bc *BuilderClient) installIdentCtx(ctx context.Context, ident BuilderPackageIdent, sink EventSink) error {
+	if identInstalled(ident) {
+		emit(sink, DependencyInstalled{Ident: ident.Ident()})
+		return nil
+	}
+
+	hartPath, err := bc.downloadCtx(ctx, ident, sink)
+	if err != nil {
+		return err
+	}
+
+	if err := unpackHart(hartPath, habPkgsDir); err != nil {
+		return errors.Wrapf(err, "unpacking %s", ident.Ident())
+	}
+	emit(sink, DependencyInstalled{Ident: ident.Ident()})
+
+	return nil
+}
+
+// identInstalled reports whether ident is already unpacked under
+// habPkgsDir.
+func identInstalled(ident BuilderPackageIdent) bool {
+	path := filepath.Join(habPkgsDir, ident.Origin, ident.Name, ident.Version, ident.Release)
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Resolve looks up the concrete origin/name/version/release for pkg
+// in the given channel (the "stable" channel if channel is empty).
+// It does not report pkg's dependencies; use InstallCtx to install
+// pkg along with its full TDEPS list.
+func (bc *BuilderClient) Resolve(pkg habpkg.Installable, channel string) (BuilderPackageIdent, error) {
+	ident, _, err := bc.resolveWithTDeps(context.Background(), pkg, channel)
+	return ident, err
+}
+
+// resolveWithTDeps looks up the concrete origin/name/version/release
+// for pkg in channel (the "stable" channel if channel is empty),
+// along with its TDEPS -- the full, ordered set of transitive
+// dependencies the Depot resolved it against.
+func (bc *BuilderClient) resolveWithTDeps(ctx context.Context, pkg habpkg.Installable, channel string) (BuilderPackageIdent, []BuilderPackageIdent, error) {
+	if channel == "" {
+		channel = "stable"
+	}
+
+	origin, name := habpkg.Origin(pkg), habpkg.Name(pkg)
+	url := fmt.Sprintf("%s/v1/depot/channels/%s/%s/pkgs/%s/latest", bc.baseURL, origin, channel, name)
+	if v := habpkg.Version(pkg); v != "" {
+		url = fmt.Sprintf("%s/v1/depot/channels/%s/%s/pkgs/%s/%s/latest", bc.baseURL, origin, channel, name, v)
+	}
+
+	resp, err := bc.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return BuilderPackageIdent{}, nil, errors.Wrapf(err, "resolving %s", habpkg.Ident(pkg))
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return BuilderPackageIdent{}, nil, errors.Errorf("package %s not found in channel %q", habpkg.Ident(pkg), channel)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BuilderPackageIdent{}, nil, errors.Errorf("unexpected status resolving %s: %s", habpkg.Ident(pkg), resp.Status)
+	}
+
+	var body struct {
+		Ident struct {
+			Origin  string `json:"origin"`
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Release string `json:"release"`
+		} `json:"ident"`
+		TDeps []struct {
+			Origin  string `json:"origin"`
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Release string `json:"release"`
+		} `json:"tdeps"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return BuilderPackageIdent{}, nil, errors.Wrapf(err, "decoding resolve response for %s", habpkg.Ident(pkg))
+	}
+
+	ident := BuilderPackageIdent{
+		Origin:  body.Ident.Origin,
+		Name:    body.Ident.Name,
+		Version: body.Ident.Version,
+		Release: body.Ident.Release,
+	}
+
+	tdeps := make([]BuilderPackageIdent, len(body.TDeps))
+	for i, d := range body.TDeps {
+		tdeps[i] = BuilderPackageIdent{
+			Origin:  d.Origin,
+			Name:    d.Name,
+			Version: d.Version,
+			Release: d.Release,
+		}
+	}
+
+	return ident, tdeps, nil
+}
+
+// Download fetches the .hart for the resolved ident into
+// /hab/cache/artifacts, verifies its origin signature, and returns
+// the path to the downloaded artifact.
+func (bc *BuilderClient) Download(ident BuilderPackageIdent) (string, error) {
+	return bc.downloadCtx(context.Background(), ident, nil)
+}
+
+// downloadCtx is the shared implementation behind Download and
+// InstallCtx. It reports DownloadProgress events to sink (which may
+// be nil) as the hart body is copied to disk.
+func (bc *BuilderClient) downloadCtx(ctx context.Context, ident BuilderPackageIdent, sink EventSink) (string, error) {
+	url := fmt.Sprintf("%s/v1/depot/pkgs/%s/%s/%s/%s/download", bc.baseURL, ident.Origin, ident.Name, ident.Version, ident.Release)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	if bc.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bc.authToken)
+	}
+
+	resp, err := bc.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "downloading %s", ident.Ident())
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", errors.Errorf("auth failure downloading %s: %s", ident.Ident(), resp.Status)
+	case http.StatusOK:
+		// fall through
+	default:
+		return "", errors.Errorf("unexpected status downloading %s: %s", ident.Ident(), resp.Status)
+	}
+
+	if err := os.MkdirAll(habArtifactCacheDir, 0755); err != nil {
+		return "", errors.Wrap(err, "creating artifact cache dir")
+	}
+
+	hartName := fmt.Sprintf("%s-%s-%s-%s-x86_64-linux.hart", ident.Origin, ident.Name, ident.Version, ident.Release)
+	hartPath := filepath.Join(habArtifactCacheDir, hartName)
+
+	f, err := os.Create(hartPath)
+	if err != nil {
+		return "", errors.Wrap(err, "creating hart file")
+	}
+	defer f.Close() // nolint: errcheck
+
+	pr := &progressReader{r: resp.Body, total: resp.ContentLength, sink: sink}
+	if _, err := io.Copy(f, pr); err != nil {
+		os.Remove(hartPath) // nolint: errcheck
+		return "", errors.Wrap(err, "writing hart file")
+	}
+
+	if err := bc.verifySignature(ctx, hartPath, ident.Origin); err != nil {
+		os.Remove(hartPath) // nolint: errcheck
+		return "", errors.Wrapf(err, "verifying signature of %s", ident.Ident())
+	}
+
+	return hartPath, nil
+}
+
+// progressReader wraps an io.Reader, emitting a DownloadProgress
+// event to sink after every Read.
+type progressReader struct {
+	r     io.Reader
+	total int64
+	read  int64
+	sink  EventSink
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if n > 0 {
+		p.read += int64(n)
+		emit(p.sink, DownloadProgress{Bytes: p.read, Total: p.total})
+	}
+	return n, err
+}
+
+// verifySignature checks the origin signature embedded in a hart
+// file's header against the origin's public key, which is fetched
+// from the Depot and cached locally on first use.
+func (bc *BuilderClient) verifySignature(ctx context.Context, hartPath, origin string) error {
+	key, err := bc.originPublicKey(ctx, origin)
+	if err != nil {
+		return errors.Wrap(err, "loading origin public key")
+	}
+
+	f, err := os.Open(hartPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+
+	sig, payloadOffset, err := parseHartHeader(f)
+	if err != nil {
+		return errors.Wrap(err, "parsing hart signature header")
+	}
+
+	payload, err := io.ReadAll(io.NewSectionReader(f, payloadOffset, 1<<62))
+	if err != nil {
+		return errors.Wrap(err, "reading hart payload")
+	}
+
+	// Habitat signs the BLAKE2b-256 hash of the payload, not the raw
+	// payload bytes.
+	hash := blake2b.Sum256(payload)
+	if !ed25519.Verify(key, hash[:], sig) {
+		return errors.New("origin signature does not match package contents")
+	}
+
+	return nil
+}
+
+// originPublicKey returns the cached public key for origin,
+// downloading and caching it from the Depot if it isn't already
+// present in keyCacheDir.
+func (bc *BuilderClient) originPublicKey(ctx context.Context, origin string) (ed25519.PublicKey, error) {
+	if err := os.MkdirAll(bc.keyCacheDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "creating key cache dir")
+	}
+
+	keyPath := filepath.Join(bc.keyCacheDir, origin+".pub")
+	if data, err := os.ReadFile(keyPath); err == nil {
+		return decodeHabitatPublicKey(data)
+	}
+
+	url := fmt.Sprintf("%s/v1/depot/origins/%s/keys/latest", bc.baseURL, origin)
+	resp, err := bc.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching public key for origin %s", origin)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status fetching public key for origin %s: %s", origin, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(keyPath, data, 0644); err != nil {
+		return nil, errors.Wrap(err, "caching origin public key")
+	}
+
+	return decodeHabitatPublicKey(data)
+}
+
+// decodeHabitatPublicKey extracts the raw Ed25519 public key from a
+// Habitat origin key file, which is a small text header followed by
+// a base64-encoded key.
+func decodeHabitatPublicKey(data []byte) (ed25519.PublicKey, error) {
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 4 {
+		return nil, errors.New("malformed origin key file")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key body")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// parseHartHeader reads the plaintext header at the start of a hart
+// file -- four lines (format, ident, compression, and the
+// base64-encoded origin signature) followed by a blank separator line
+// -- and returns the decoded signature along with the byte offset at
+// which the compressed package payload begins, immediately after that
+// blank line.
+func parseHartHeader(r io.Reader) (sig []byte, payloadOffset int64, err error) {
+	br := bufio.NewReader(r)
+	var consumed int64
+	for i := 0; i < 5; i++ {
+		line, readErr := br.ReadString('\n')
+		consumed += int64(len(line))
+		if readErr != nil && readErr != io.EOF {
+			return nil, 0, readErr
+		}
+		if i == 3 {
+			sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "decoding hart signature")
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return sig, consumed, nil
+}
+
+// unpackHart extracts the xz-compressed tarball making up a hart's
+// payload (everything after the plaintext signature header) into
+// destDir, which should be habPkgsDir. Entries are expected to carry
+// the "hab/pkgs/" prefix hab itself lays packages out under; that
+// prefix is stripped before each entry is joined onto destDir and
+// checked for containment, so a corrupted or malicious hart cannot
+// escape destDir via "../" components or an unexpected entry root.
+func unpackHart(hartPath, destDir string) error {
+	f, err := os.Open(hartPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+
+	_, payloadOffset, err := parseHartHeader(f)
+	if err != nil {
+		return errors.Wrap(err, "re-reading hart signature header")
+	}
+	if _, err := f.Seek(payloadOffset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seeking to hart payload")
+	}
+
+	xr, err := xz.NewReader(f)
+	if err != nil {
+		return errors.Wrap(err, "opening xz payload")
+	}
+
+	tr := tar.NewReader(xr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(hdr.Name, hartPkgsPrefix)
+		if rel == hdr.Name {
+			return errors.Errorf("hart entry %q is outside %s", hdr.Name, hartPkgsPrefix)
+		}
+
+		target, err := safeJoinExtractPath(destDir, rel)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close() // nolint: errcheck
+				return err
+			}
+			out.Close() // nolint: errcheck
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target) // nolint: errcheck
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("hart entry %q has unsupported type %v", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// doRequest issues an HTTP request against the Builder Depot,
+// attaching the configured HAB_AUTH_TOKEN when present, and honors
+// ctx cancellation for the duration of the request.
+func (bc *BuilderClient) doRequest(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if bc.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bc.authToken)
+	}
+	return bc.httpClient.Do(req)
+}
@@ -0,0 +1,207 @@
+package target
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/blake2b"
+)
+
+// signedHart builds a minimal, validly-signed hart file containing a
+// single regular file at hab/pkgs/<ident>/<name> with the given
+// content, signed by key. It returns the hart's bytes.
+func signedHart(t *testing.T, key ed25519.PrivateKey, ident BuilderPackageIdent, name string, content []byte) []byte {
+	t.Helper()
+
+	var tarBuf bytes.Buffer
+	xzw, err := xz.NewWriter(&tarBuf)
+	if err != nil {
+		t.Fatalf("creating xz writer: %v", err)
+	}
+	tw := tar.NewWriter(xzw)
+	entryName := fmt.Sprintf("hab/pkgs/%s/%s/%s/%s/%s", ident.Origin, ident.Name, ident.Version, ident.Release, name)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     entryName,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+	}); err != nil {
+		t.Fatalf("writing tar header: %v", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatalf("writing tar content: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	if err := xzw.Close(); err != nil {
+		t.Fatalf("closing xz writer: %v", err)
+	}
+
+	payload := tarBuf.Bytes()
+	hash := blake2b.Sum256(payload)
+	sig := ed25519.Sign(key, hash[:])
+
+	var hart bytes.Buffer
+	fmt.Fprintf(&hart, "HART-1\n%s\nxz\n%s\n\n", ident.Ident(), base64.StdEncoding.EncodeToString(sig))
+	hart.Write(payload)
+
+	return hart.Bytes()
+}
+
+// originKeyFile renders a Habitat origin public key file in the text
+// format decodeHabitatPublicKey expects.
+func originKeyFile(pub ed25519.PublicKey) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "SIG-PUB-1\ntest-20200101000000\n\n%s\n", base64.StdEncoding.EncodeToString(pub))
+	return buf.Bytes()
+}
+
+func TestBuilderClientOriginPublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v1/depot/origins/test/keys/latest" {
+			t.Errorf("unexpected request path %q", r.URL.Path)
+		}
+		w.Write(originKeyFile(pub)) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	keyCacheDir := t.TempDir()
+	bc := &BuilderClient{baseURL: server.URL, httpClient: server.Client(), keyCacheDir: keyCacheDir}
+
+	key, err := bc.originPublicKey(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("originPublicKey returned error: %v", err)
+	}
+	if !bytes.Equal(key, pub) {
+		t.Error("returned key does not match the key served by the depot")
+	}
+
+	if _, err := os.Stat(filepath.Join(keyCacheDir, "test.pub")); err != nil {
+		t.Errorf("expected the key to be cached on disk: %v", err)
+	}
+
+	// A second call should be served from the cache, not the depot.
+	if _, err := bc.originPublicKey(context.Background(), "test"); err != nil {
+		t.Fatalf("originPublicKey (cached) returned error: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("depot was queried %d times, want 1 (second call should hit the cache)", requests)
+	}
+}
+
+func TestBuilderClientOriginPublicKeyUnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	bc := &BuilderClient{baseURL: server.URL, httpClient: server.Client(), keyCacheDir: t.TempDir()}
+	if _, err := bc.originPublicKey(context.Background(), "test"); err == nil {
+		t.Fatal("expected an error for a non-200 response, got nil")
+	}
+}
+
+func TestBuilderClientVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(originKeyFile(pub)) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	ident := BuilderPackageIdent{Origin: "test", Name: "pkgname", Version: "1.0.0", Release: "20200101000000"}
+	hart := signedHart(t, priv, ident, "README", []byte("hello from the depot"))
+
+	dir := t.TempDir()
+	hartPath := filepath.Join(dir, "test.hart")
+	if err := os.WriteFile(hartPath, hart, 0644); err != nil {
+		t.Fatalf("writing hart file: %v", err)
+	}
+
+	bc := &BuilderClient{baseURL: server.URL, httpClient: server.Client(), keyCacheDir: t.TempDir()}
+	if err := bc.verifySignature(context.Background(), hartPath, ident.Origin); err != nil {
+		t.Errorf("verifySignature returned error: %v", err)
+	}
+}
+
+func TestBuilderClientVerifySignatureRejectsWrongKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating second key: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Serve a key file for an unrelated keypair, so the hart's
+		// embedded signature no longer verifies against it.
+		w.Write(originKeyFile(otherPub)) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	ident := BuilderPackageIdent{Origin: "test", Name: "pkgname", Version: "1.0.0", Release: "20200101000000"}
+	hart := signedHart(t, priv, ident, "README", []byte("hello from the depot"))
+
+	dir := t.TempDir()
+	hartPath := filepath.Join(dir, "test.hart")
+	if err := os.WriteFile(hartPath, hart, 0644); err != nil {
+		t.Fatalf("writing hart file: %v", err)
+	}
+
+	bc := &BuilderClient{baseURL: server.URL, httpClient: server.Client(), keyCacheDir: t.TempDir()}
+	if err := bc.verifySignature(context.Background(), hartPath, ident.Origin); err == nil {
+		t.Fatal("expected a signature verification error, got nil")
+	}
+}
+
+func TestUnpackHartExtractsPayload(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	ident := BuilderPackageIdent{Origin: "test", Name: "pkgname", Version: "1.0.0", Release: "20200101000000"}
+	hart := signedHart(t, priv, ident, "README", []byte("hello from the depot"))
+
+	dir := t.TempDir()
+	hartPath := filepath.Join(dir, "test.hart")
+	if err := os.WriteFile(hartPath, hart, 0644); err != nil {
+		t.Fatalf("writing hart file: %v", err)
+	}
+
+	destDir := t.TempDir()
+	if err := unpackHart(hartPath, destDir); err != nil {
+		t.Fatalf("unpackHart returned error: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, ident.Origin, ident.Name, ident.Version, ident.Release, "README"))
+	if err != nil {
+		t.Fatalf("reading extracted file: %v", err)
+	}
+	if string(got) != "hello from the depot" {
+		t.Errorf("extracted content = %q, want %q", got, "hello from the depot")
+	}
+}
@@ -0,0 +1,49 @@
+package target
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+func TestParseHartHeader(t *testing.T) {
+	sig := []byte("this-is-not-a-real-signature")
+	header := "HART-1\n" +
+		"core/pkgname/1.0.0/20200101000000\n" +
+		"xz\n" +
+		base64.StdEncoding.EncodeToString(sig) + "\n" +
+		"\n"
+	payload := "fake-xz-payload"
+
+	gotSig, payloadOffset, err := parseHartHeader(bytes.NewReader([]byte(header + payload)))
+	if err != nil {
+		t.Fatalf("parseHartHeader returned error: %v", err)
+	}
+	if !bytes.Equal(gotSig, sig) {
+		t.Errorf("signature = %q, want %q", gotSig, sig)
+	}
+	if int(payloadOffset) != len(header) {
+		t.Errorf("payloadOffset = %d, want %d", payloadOffset, len(header))
+	}
+
+	r := bytes.NewReader([]byte(header + payload))
+	if _, err := r.Seek(payloadOffset, io.SeekStart); err != nil {
+		t.Fatalf("seeking to payload offset: %v", err)
+	}
+	gotPayload, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	if string(gotPayload) != payload {
+		t.Errorf("payload = %q, want %q", gotPayload, payload)
+	}
+}
+
+func TestParseHartHeaderMalformedSignature(t *testing.T) {
+	header := "HART-1\ncore/pkgname/1.0.0/20200101000000\nxz\nnot-valid-base64!!!\n\n"
+	_, _, err := parseHartHeader(bytes.NewReader([]byte(header)))
+	if err == nil {
+		t.Fatal("expected an error decoding a malformed signature, got nil")
+	}
+}
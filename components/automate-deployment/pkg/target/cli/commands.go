@@ -0,0 +1,75 @@
+// Package cli provides cobra commands that wire HabCmd's node
+// bootstrap and offline-install operations into the chef-automate
+// deploy CLI. automate-cli mounts these under `chef-automate deploy`
+// and supplies the HabCmd factory, which is responsible for
+// constructing a target.HabCmd backed by the real command.Executor.
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/chef/automate/components/automate-deployment/pkg/target"
+)
+
+// HabCmdFactory constructs the target.HabCmd a command should
+// operate against. automate-cli supplies the real implementation
+// (backed by lib/platform/command's process executor); tests can
+// substitute a fake.
+type HabCmdFactory func() target.HabCmd
+
+var (
+	bootstrapHabVersion          string
+	bootstrapAcceptLicense       bool
+	bootstrapPeer                string
+	bootstrapListenGossip        string
+	bootstrapListenHTTP          string
+	bootstrapRing                string
+	bootstrapAuthToken           string
+	bootstrapSupGatewayAuthToken string
+)
+
+// NewLoadOfflineBundleCmd returns the `deploy load-offline-bundle`
+// command, which stages and installs every package in the offline
+// bootstrap bundle tarball at path via target.HabCmd.LoadOfflineBundle.
+func NewLoadOfflineBundleCmd(newHabCmd HabCmdFactory) *cobra.Command {
+	return &cobra.Command{
+		Use:   "load-offline-bundle PATH",
+		Short: "Install every package in an offline bootstrap bundle",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return newHabCmd().LoadOfflineBundle(args[0])
+		},
+	}
+}
+
+// NewBootstrapSupervisorCmd returns the `deploy bootstrap-supervisor`
+// command, which installs hab and brings up a running Habitat
+// Supervisor on a bare node via target.BootstrapNode.
+func NewBootstrapSupervisorCmd(newHabCmd HabCmdFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bootstrap-supervisor",
+		Short: "Install hab and bring up the Habitat Supervisor on this node",
+		RunE: func(*cobra.Command, []string) error {
+			opts := target.SupervisorOptions{
+				Peer:                bootstrapPeer,
+				ListenGossip:        bootstrapListenGossip,
+				ListenHTTP:          bootstrapListenHTTP,
+				Ring:                bootstrapRing,
+				AuthToken:           bootstrapAuthToken,
+				SupGatewayAuthToken: bootstrapSupGatewayAuthToken,
+			}
+			return target.BootstrapNode(newHabCmd(), bootstrapHabVersion, bootstrapAcceptLicense, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&bootstrapHabVersion, "hab-version", "", "pin hab to this version instead of latest")
+	cmd.Flags().BoolVar(&bootstrapAcceptLicense, "accept-license", true, "accept the Habitat license")
+	cmd.Flags().StringVar(&bootstrapPeer, "peer", "", "gossip peer for the supervisor to join")
+	cmd.Flags().StringVar(&bootstrapListenGossip, "listen-gossip", "", "gossip listen address")
+	cmd.Flags().StringVar(&bootstrapListenHTTP, "listen-http", "", "HTTP gateway listen address")
+	cmd.Flags().StringVar(&bootstrapRing, "ring", "", "ring encryption key name")
+	cmd.Flags().StringVar(&bootstrapAuthToken, "auth-token", "", "HAB_AUTH_TOKEN for the supervisor")
+	cmd.Flags().StringVar(&bootstrapSupGatewayAuthToken, "sup-gateway-auth-token", "", "HAB_SUP_GATEWAY_AUTH_TOKEN for the supervisor's HTTP gateway")
+
+	return cmd
+}
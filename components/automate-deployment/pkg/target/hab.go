@@ -0,0 +1,267 @@
+package target
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/chef/automate/components/automate-deployment/pkg/habpkg"
+	"github.com/chef/automate/lib/platform/command"
+)
+
+const (
+	// HabTimeoutInstallPackage is the timeout for InstallPackage
+	// commands. Since package installs also install dependencies,
+	// a given package installation can often take considerable
+	// time.
+	HabTimeoutInstallPackage = 1200 * time.Second
+	// HabTimeoutIsInstalled is the timeout for
+	// IsInstalled. IsInstalled runs hab pkg path which we expect
+	// to be very fast typically.
+	HabTimeoutIsInstalled = 60 * time.Second
+	// HabTimeoutDefault is the timeout for hab commands that
+	// don't have other timeouts.
+	HabTimeoutDefault = 300 * time.Second
+)
+
+// A HabCmd runs the `hab` command-line tool with a standard set of
+// options.
+type HabCmd interface {
+	// InstallPackage installs an Installable habitat package
+	// (a hartifact or a package from the Depot)
+	InstallPackage(habpkg.Installable, string) (string, error)
+	// IsInstalled returns true if the specified package is
+	// installed and false otherwise. A failing `hab pkg path` is
+	// reported as not installed, and the returned error is nil.
+	IsInstalled(habpkg.VersionedPackage) (bool, error)
+	// BinlinkPackage binlinks a binary in the given Habitat
+	// package. An error is returned if the underlying hab command
+	// failed.
+	BinlinkPackage(habpkg.VersionedPackage, string) (string, error)
+
+	// LoadService loads the given habpkg.VersionedPackage as a service
+	// with the provided options.
+	LoadService(habpkg.VersionedPackage, ...LoadOption) (string, error)
+	// UnloadService unloads a given habpkg.VersionedPackage
+	UnloadService(habpkg.VersionedPackage) (string, error)
+	// StartService starts an already-loaded service identified by
+	// the given habpkg.VersionedPackage.
+	StartService(habpkg.VersionedPackage) (string, error)
+	// StopService stops an already-loaded service identified by
+	// the given habpkg.VersionedPackage.
+	StopService(habpkg.VersionedPackage) (string, error)
+
+	// InstallHab installs the hab binary itself by running the
+	// official install.sh, optionally pinned to version, and
+	// accepts the Habitat license if acceptLicense is true. This
+	// lets automate-deployment provision a Habitat runtime on a
+	// host that doesn't have hab present yet.
+	InstallHab(version string, acceptLicense bool) error
+	// BootstrapSupervisor provisions a hab-sup systemd unit from
+	// the given SupervisorOptions and starts it, creating the hab
+	// user/group if necessary. hab must already be installed (see
+	// InstallHab) before calling BootstrapSupervisor.
+	BootstrapSupervisor(opts SupervisorOptions) error
+
+	// LoadOfflineBundle unpacks the offline bundle tarball at path
+	// into hab's artifact and key caches and installs every package
+	// it contains with `hab pkg install --offline`, in the
+	// dependency order recorded in the bundle's manifest.
+	LoadOfflineBundle(path string) error
+}
+
+// updateStrategies are the update strategies accepted by `hab svc
+// load --strategy`.
+var updateStrategies = map[string]bool{
+	"none":    true,
+	"at-once": true,
+	"rolling": true,
+}
+
+// topologies are the topologies accepted by `hab svc load
+// --topology`.
+var topologies = map[string]bool{
+	"standalone": true,
+	"leader":     true,
+}
+
+// loadOpts accumulates the options passed to LoadService so that
+// LoadOptions can be validated and combined before the final argument
+// list is built, rather than appending flags to the command line
+// one-by-one.
+type loadOpts struct {
+	binds               []string
+	bindMode            string
+	strategy            string
+	channel             string
+	topology            string
+	serviceGroup        string
+	application         string
+	environment         string
+	healthCheckInterval time.Duration
+}
+
+// A LoadOption customizes the arguments passed to `hab svc load`.
+type LoadOption func(*loadOpts) error
+
+// Binds is a LoadOption that applies the passed bind to the service's
+// load command line arguments.
+func Binds(binds []string) LoadOption {
+	return func(o *loadOpts) error {
+		o.binds = binds
+		return nil
+	}
+}
+
+// BindMode is a LoadOption that applies the passed binding mode to
+// the service's load command line arguments.
+func BindMode(mode string) LoadOption {
+	return func(o *loadOpts) error {
+		o.bindMode = mode
+		return nil
+	}
+}
+
+// UpdateStrategy is a LoadOption that sets the update strategy and
+// channel for the loaded service. strategy must be one of "none",
+// "at-once", or "rolling", or empty to keep the current strategy
+// ("none" by default); an empty channel leaves the service on hab's
+// default channel.
+func UpdateStrategy(strategy, channel string) LoadOption {
+	return func(o *loadOpts) error {
+		if strategy != "" && !updateStrategies[strategy] {
+			return errors.Errorf("invalid update strategy %q: must be one of none, at-once, rolling", strategy)
+		}
+		if strategy != "" {
+			o.strategy = strategy
+		}
+		o.channel = channel
+		return nil
+	}
+}
+
+// Topology is a LoadOption that sets the service topology. t must be
+// one of "standalone" or "leader", or empty to omit --topology and
+// use hab's default.
+func Topology(t string) LoadOption {
+	return func(o *loadOpts) error {
+		if t != "" && !topologies[t] {
+			return errors.Errorf("invalid topology %q: must be one of standalone, leader", t)
+		}
+		o.topology = t
+		return nil
+	}
+}
+
+// ServiceGroup is a LoadOption that loads the service into the given
+// service group instead of hab's "default" group.
+func ServiceGroup(group string) LoadOption {
+	return func(o *loadOpts) error {
+		o.serviceGroup = group
+		return nil
+	}
+}
+
+// Application is a LoadOption that tags the loaded service with the
+// given application and environment, as used by Habitat's
+// application/environment rollups. The flags are passed only when
+// both app and env are non-empty; otherwise neither is passed.
+func Application(app, env string) LoadOption {
+	return func(o *loadOpts) error {
+		o.application = app
+		o.environment = env
+		return nil
+	}
+}
+
+// HealthCheckInterval is a LoadOption that sets how often hab runs
+// the service's health check hook.
+func HealthCheckInterval(d time.Duration) LoadOption {
+	return func(o *loadOpts) error {
+		o.healthCheckInterval = d
+		return nil
+	}
+}
+
+type habCmd struct {
+	offlineMode   bool
+	executor      command.Executor
+	builderClient *BuilderClient
+}
+
+// A HabCmdOption customizes the habCmd returned by NewHabCmd.
+type HabCmdOption func(*habCmd)
+
+// WithBuilderClient configures habCmd to resolve and download
+// packages directly through bc instead of shelling out to `hab pkg
+// install`. If bc is nil, or the habCmd is in offline mode,
+// InstallPackage falls back to the hab CLI.
+func WithBuilderClient(bc *BuilderClient) HabCmdOption {
+	return func(c *habCmd) {
+		c.builderClient = bc
+	}
+}
+
+// NewHabCmd returns an habCmd that uses the given
+// command.Executor. If offlineMode is true then any InstallPackage()
+// calls will use Habitat's OFFLINE_INSTALL feature.
+func NewHabCmd(c command.Executor, offlineMode bool, opts ...HabCmdOption) HabCmd {
+	hc := &habCmd{
+		executor:    c,
+		offlineMode: offlineMode,
+	}
+	for _, o := range opts {
+		o(hc)
+	}
+	return hc
+}
+
+// Install installs the given Installable. If the install fails an
+// error is returned.
+//
+// TODO(ssd) 2018-07-16: Can we rip channel out of here?  I don't
+// think anything really uses channel anymore.
+func (c *habCmd) InstallPackage(pkg habpkg.Installable, channel string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutInstallPackage)
+	defer cancel()
+	return c.InstallPackageCtx(ctx, pkg, channel, nil)
+}
+
+// IsInstalled checks if a package is already installed
+func (c *habCmd) IsInstalled(pkg habpkg.VersionedPackage) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutIsInstalled)
+	defer cancel()
+	return c.IsInstalledCtx(ctx, pkg, nil)
+}
+
+// BinlinkPackage binlinks an executable from a Habitat package
+func (c *habCmd) BinlinkPackage(pkg habpkg.VersionedPackage, exe string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutDefault)
+	defer cancel()
+	return c.BinlinkPackageCtx(ctx, pkg, exe, nil)
+}
+
+func (c *habCmd) LoadService(svc habpkg.VersionedPackage, options ...LoadOption) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutDefault)
+	defer cancel()
+	return c.LoadServiceCtx(ctx, svc, nil, options...)
+}
+
+func (c *habCmd) UnloadService(svc habpkg.VersionedPackage) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutDefault)
+	defer cancel()
+	return c.UnloadServiceCtx(ctx, svc, nil)
+}
+
+func (c *habCmd) StartService(svc habpkg.VersionedPackage) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutDefault)
+	defer cancel()
+	return c.StartServiceCtx(ctx, svc, nil)
+}
+
+func (c *habCmd) StopService(svc habpkg.VersionedPackage) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HabTimeoutDefault)
+	defer cancel()
+	return c.StopServiceCtx(ctx, svc, nil)
+}
@@ -0,0 +1,177 @@
+package target
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/chef/automate/lib/platform/command"
+)
+
+const (
+	// habInstallScriptURL is the official Habitat install script,
+	// the same one the Habitat Terraform provisioner bootstraps
+	// fresh nodes with.
+	habInstallScriptURL = "https://raw.githubusercontent.com/habitat-sh/habitat/master/components/hab/install.sh"
+
+	habSupServiceUnitPath = "/etc/systemd/system/hab-sup.service"
+	habUser               = "hab"
+	habGroup              = "hab"
+)
+
+// SupervisorOptions configures the hab-sup systemd unit rendered by
+// BootstrapSupervisor. Zero-valued fields are omitted from the
+// resulting ExecStart/Environment lines so the supervisor falls back
+// to hab's own defaults.
+type SupervisorOptions struct {
+	Peer                string
+	ListenGossip        string
+	ListenHTTP          string
+	Ring                string
+	AuthToken           string
+	SupGatewayAuthToken string
+}
+
+// Validate rejects control characters (including newlines) in any
+// field of opts, since every field is interpolated directly into the
+// rendered hab-sup systemd unit: a newline would let a caller inject
+// arbitrary extra ExecStart/Environment directives into
+// /etc/systemd/system/hab-sup.service.
+func (opts SupervisorOptions) Validate() error {
+	fields := map[string]string{
+		"Peer":                opts.Peer,
+		"ListenGossip":        opts.ListenGossip,
+		"ListenHTTP":          opts.ListenHTTP,
+		"Ring":                opts.Ring,
+		"AuthToken":           opts.AuthToken,
+		"SupGatewayAuthToken": opts.SupGatewayAuthToken,
+	}
+	for name, value := range fields {
+		if strings.IndexFunc(value, isControlRune) != -1 {
+			return errors.Errorf("%s must not contain control characters", name)
+		}
+	}
+	return nil
+}
+
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+var habSupUnitTemplate = template.Must(template.New("hab-sup.service").Parse(`[Unit]
+Description=Habitat Supervisor
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=/bin/hab sup run{{if .Peer}} --peer {{.Peer}}{{end}}{{if .ListenGossip}} --listen-gossip {{.ListenGossip}}{{end}}{{if .ListenHTTP}} --listen-http {{.ListenHTTP}}{{end}}{{if .Ring}} --ring {{.Ring}}{{end}}
+{{- if .AuthToken}}
+Environment=HAB_AUTH_TOKEN={{.AuthToken}}
+{{- end}}
+{{- if .SupGatewayAuthToken}}
+Environment=HAB_SUP_GATEWAY_AUTH_TOKEN={{.SupGatewayAuthToken}}
+{{- end}}
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// BootstrapNode installs hab (pinned to habVersion if set, accepting
+// the license if acceptLicense is true) and then brings up a running
+// Habitat Supervisor configured from opts. This is the entry point
+// `chef-automate deploy` calls against a bare node that doesn't have
+// hab present yet; see the bootstrap-supervisor command in
+// pkg/target/cli for how it's wired into the deploy CLI.
+func BootstrapNode(c HabCmd, habVersion string, acceptLicense bool, opts SupervisorOptions) error {
+	if err := c.InstallHab(habVersion, acceptLicense); err != nil {
+		return errors.Wrap(err, "installing hab")
+	}
+	if err := c.BootstrapSupervisor(opts); err != nil {
+		return errors.Wrap(err, "bootstrapping supervisor")
+	}
+	return nil
+}
+
+// InstallHab downloads and runs the official Habitat install.sh,
+// optionally pinned to version, and then accepts the Habitat license
+// by running `hab -V` with HAB_LICENSE=accept if acceptLicense is
+// true. hab releases after 0.85 refuse to run until the license has
+// been accepted once.
+func (c *habCmd) InstallHab(version string, acceptLicense bool) error {
+	return c.InstallHabCtx(context.Background(), version, acceptLicense, nil)
+}
+
+// BootstrapSupervisor creates the hab user/group if they don't
+// already exist, renders a hab-sup systemd unit from opts, and
+// enables and starts it.
+func (c *habCmd) BootstrapSupervisor(opts SupervisorOptions) error {
+	return c.BootstrapSupervisorCtx(context.Background(), opts, nil)
+}
+
+// ensureHabUser creates the hab group and user if they do not
+// already exist on the system.
+func ensureHabUser(executor command.Executor) error {
+	if _, err := user.Lookup(habUser); err == nil {
+		return nil
+	}
+
+	if _, err := user.LookupGroup(habGroup); err != nil {
+		groupOpts := command.Args(habGroup)
+		if _, err := executor.CombinedOutput("groupadd", command.Timeout(HabTimeoutDefault), groupOpts); err != nil {
+			return errors.Wrap(err, "creating hab group")
+		}
+	}
+
+	userOpts := command.Args("-r", "-g", habGroup, "-s", "/bin/false", habUser)
+	if _, err := executor.CombinedOutput("useradd", command.Timeout(HabTimeoutDefault), userOpts); err != nil {
+		return errors.Wrap(err, "creating hab user")
+	}
+
+	return nil
+}
+
+// fetchInstallScript downloads the Habitat install.sh script to a
+// temporary, executable file and returns its path. The caller is
+// responsible for removing the file. The download is canceled if ctx
+// is done before it completes.
+func fetchInstallScript(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", habInstallScriptURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status fetching install.sh: %s", resp.Status)
+	}
+
+	f, err := os.CreateTemp("", "hab-install-*.sh")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint: errcheck
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		os.Remove(f.Name()) // nolint: errcheck
+		return "", err
+	}
+
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		os.Remove(f.Name()) // nolint: errcheck
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,344 @@
+package target
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/go-linereader"
+	"github.com/pkg/errors"
+
+	"github.com/chef/automate/components/automate-deployment/pkg/habpkg"
+)
+
+// HabCmdCtx is implemented by HabCmd implementations that support
+// context cancellation and progress reporting. Unlike the plain
+// HabCmd methods, which only return combined stdout/stderr once the
+// underlying hab command has finished, the Ctx methods here parse
+// hab's line-oriented output as it is produced and emit typed events
+// to sink, and they cancel the underlying process when ctx is done.
+// sink may be nil, in which case events are simply dropped.
+type HabCmdCtx interface {
+	InstallPackageCtx(ctx context.Context, pkg habpkg.Installable, channel string, sink EventSink) (string, error)
+	IsInstalledCtx(ctx context.Context, pkg habpkg.VersionedPackage, sink EventSink) (bool, error)
+	BinlinkPackageCtx(ctx context.Context, pkg habpkg.VersionedPackage, exe string, sink EventSink) (string, error)
+	LoadServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink, options ...LoadOption) (string, error)
+	UnloadServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink) (string, error)
+	StartServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink) (string, error)
+	StopServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink) (string, error)
+	InstallHabCtx(ctx context.Context, version string, acceptLicense bool, sink EventSink) error
+	BootstrapSupervisorCtx(ctx context.Context, opts SupervisorOptions, sink EventSink) error
+	LoadOfflineBundleCtx(ctx context.Context, path string, sink EventSink) error
+}
+
+var (
+	habInstallingRe = regexp.MustCompile(`^[→\s]*[Ii]nstalling (\S+)`)
+	habInstalledRe  = regexp.MustCompile(`^[★\s]*[Ii]nstalled (\S+)`)
+)
+
+// InstallPackageCtx behaves like InstallPackage, but accepts a
+// context for cancellation and emits PackageResolved/
+// DependencyInstalled events as hab reports them. When a
+// BuilderClient is configured via WithBuilderClient and offline mode
+// is off, it is used instead of the hab CLI, which additionally
+// allows DownloadProgress events to be emitted.
+func (c *habCmd) InstallPackageCtx(ctx context.Context, pkg habpkg.Installable, channel string, sink EventSink) (string, error) {
+	if c.builderClient != nil && !c.offlineMode {
+		return "", c.builderClient.InstallCtx(ctx, pkg, channel, sink)
+	}
+
+	args := []string{"pkg", "install", pkg.InstallIdent()}
+	if c.offlineMode {
+		args = append(args, "--offline")
+	}
+	if channel != "" {
+		args = append(args, "--channel", channel)
+	}
+
+	env := habEnv()
+	if c.offlineMode {
+		env = append(env, "HAB_FEAT_OFFLINE_INSTALL=true")
+	}
+
+	return runHabStreamed(ctx, "hab", args, env, sink, func(line string) Event {
+		if m := habInstallingRe.FindStringSubmatch(line); m != nil {
+			return PackageResolved{Ident: m[1]}
+		}
+		if m := habInstalledRe.FindStringSubmatch(line); m != nil {
+			return DependencyInstalled{Ident: m[1]}
+		}
+		return nil
+	})
+}
+
+// IsInstalledCtx behaves like IsInstalled, but accepts a context for
+// cancellation.
+func (c *habCmd) IsInstalledCtx(ctx context.Context, pkg habpkg.VersionedPackage, sink EventSink) (bool, error) {
+	args := []string{"pkg", "path", habpkg.Ident(pkg)}
+	if _, err := runHabStreamed(ctx, "hab", args, habEnv(), sink, nil); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// BinlinkPackageCtx behaves like BinlinkPackage, but accepts a
+// context for cancellation.
+func (c *habCmd) BinlinkPackageCtx(ctx context.Context, pkg habpkg.VersionedPackage, exe string, sink EventSink) (string, error) {
+	args := []string{"pkg", "binlink", "--force", habpkg.Ident(pkg), exe}
+	return runHabStreamed(ctx, "hab", args, habEnv(), sink, nil)
+}
+
+// LoadServiceCtx behaves like LoadService, but accepts a context for
+// cancellation and emits a ServiceLoaded event once hab reports the
+// service as loaded.
+func (c *habCmd) LoadServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink, options ...LoadOption) (string, error) {
+	o := &loadOpts{strategy: "none"}
+	for _, option := range options {
+		if err := option(o); err != nil {
+			return "", err
+		}
+	}
+
+	args := loadServiceArgs(svc, o)
+	ident := habpkg.Ident(svc)
+
+	return runHabStreamed(ctx, "hab", args, habEnv(), sink, func(line string) Event {
+		if habServiceLoadedRe.MatchString(line) {
+			return ServiceLoaded{Ident: ident}
+		}
+		return nil
+	})
+}
+
+// habServiceLoadedRe matches hab's "The <ident> service was
+// successfully loaded" message, case-insensitively. It requires the
+// whole phrase, so unrelated lines like "... successfully uploaded
+// revision 1" don't match.
+var habServiceLoadedRe = regexp.MustCompile(`(?i)\bservice was successfully loaded\b`)
+
+// UnloadServiceCtx behaves like UnloadService, but accepts a context
+// for cancellation.
+func (c *habCmd) UnloadServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink) (string, error) {
+	args := []string{"svc", "unload", habpkg.ShortIdent(svc)}
+	return runHabStreamed(ctx, "hab", args, habEnv(), sink, nil)
+}
+
+// StartServiceCtx behaves like StartService, but accepts a context
+// for cancellation.
+func (c *habCmd) StartServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink) (string, error) {
+	args := []string{"svc", "start", habpkg.ShortIdent(svc)}
+	return runHabStreamed(ctx, "hab", args, habEnv(), sink, nil)
+}
+
+// StopServiceCtx behaves like StopService, but accepts a context for
+// cancellation.
+func (c *habCmd) StopServiceCtx(ctx context.Context, svc habpkg.VersionedPackage, sink EventSink) (string, error) {
+	args := []string{"svc", "stop", habpkg.ShortIdent(svc)}
+	return runHabStreamed(ctx, "hab", args, habEnv(), sink, nil)
+}
+
+// InstallHabCtx behaves like InstallHab, but accepts a context for
+// cancellation and emits a LicenseAccepted event once the license has
+// been accepted.
+func (c *habCmd) InstallHabCtx(ctx context.Context, version string, acceptLicense bool, sink EventSink) error {
+	scriptPath, err := fetchInstallScript(ctx)
+	if err != nil {
+		return errors.Wrap(err, "downloading hab install script")
+	}
+	defer os.Remove(scriptPath)
+
+	args := []string{scriptPath}
+	if version != "" {
+		args = append(args, "-v", version)
+	}
+	if _, err := runHabStreamed(ctx, "bash", args, os.Environ(), sink, nil); err != nil {
+		return errors.Wrap(err, "running hab install script")
+	}
+
+	if acceptLicense {
+		env := append(habEnv(), "HAB_LICENSE=accept")
+		if _, err := runHabStreamed(ctx, "hab", []string{"-V"}, env, sink, nil); err != nil {
+			return errors.Wrap(err, "accepting hab license")
+		}
+		emit(sink, LicenseAccepted{})
+	}
+
+	return nil
+}
+
+// BootstrapSupervisorCtx behaves like BootstrapSupervisor, but
+// accepts a context for cancellation of the systemctl commands it
+// shells out to.
+func (c *habCmd) BootstrapSupervisorCtx(ctx context.Context, opts SupervisorOptions, sink EventSink) error {
+	if err := opts.Validate(); err != nil {
+		return errors.Wrap(err, "validating supervisor options")
+	}
+
+	if err := ensureHabUser(c.executor); err != nil {
+		return errors.Wrap(err, "creating hab user/group")
+	}
+
+	unitFile, err := os.Create(habSupServiceUnitPath)
+	if err != nil {
+		return errors.Wrap(err, "creating hab-sup systemd unit")
+	}
+	defer unitFile.Close() // nolint: errcheck
+
+	if err := habSupUnitTemplate.Execute(unitFile, opts); err != nil {
+		return errors.Wrap(err, "rendering hab-sup systemd unit")
+	}
+
+	if _, err := runHabStreamed(ctx, "systemctl", []string{"daemon-reload"}, os.Environ(), sink, nil); err != nil {
+		return errors.Wrap(err, "reloading systemd units")
+	}
+
+	if _, err := runHabStreamed(ctx, "systemctl", []string{"enable", "--now", "hab-sup.service"}, os.Environ(), sink, nil); err != nil {
+		return errors.Wrap(err, "enabling hab-sup service")
+	}
+
+	return nil
+}
+
+// LoadOfflineBundleCtx behaves like LoadOfflineBundle, but accepts a
+// context for cancellation and emits a DependencyInstalled event as
+// each package in the bundle finishes installing.
+func (c *habCmd) LoadOfflineBundleCtx(ctx context.Context, path string, sink EventSink) error {
+	stagingDir, err := os.MkdirTemp("", "hab-offline-bundle-")
+	if err != nil {
+		return errors.Wrap(err, "creating staging directory")
+	}
+	defer os.RemoveAll(stagingDir) // nolint: errcheck
+
+	if err := extractTarGz(path, stagingDir); err != nil {
+		return errors.Wrap(err, "extracting offline bundle")
+	}
+
+	manifest, err := readOfflineManifest(filepath.Join(stagingDir, offlineBundleManifestName))
+	if err != nil {
+		return errors.Wrap(err, "reading offline bundle manifest")
+	}
+
+	if err := os.MkdirAll(habKeyCacheDir, 0755); err != nil {
+		return errors.Wrap(err, "creating key cache dir")
+	}
+	if err := os.MkdirAll(habArtifactCacheDir, 0755); err != nil {
+		return errors.Wrap(err, "creating artifact cache dir")
+	}
+
+	if err := stageOfflineKeys(filepath.Join(stagingDir, "keys")); err != nil {
+		return err
+	}
+
+	env := append(habEnv(), "HAB_FEAT_OFFLINE_INSTALL=true")
+	for _, pkg := range manifest.Packages {
+		if strings.ContainsAny(pkg.Hart, `/\`) {
+			return errors.Errorf("invalid hart filename %q: must not contain a path separator", pkg.Hart)
+		}
+		hartDst, err := safeJoinExtractPath(habArtifactCacheDir, pkg.Hart)
+		if err != nil {
+			return errors.Wrapf(err, "validating %s", pkg.Hart)
+		}
+
+		hartSrc := filepath.Join(stagingDir, "harts", pkg.Hart)
+		if err := verifySHA256(hartSrc, pkg.SHA256); err != nil {
+			return errors.Wrapf(err, "verifying %s", pkg.Hart)
+		}
+
+		if err := copyFile(hartSrc, hartDst, 0644); err != nil {
+			return errors.Wrapf(err, "staging %s", pkg.Hart)
+		}
+
+		args := []string{"pkg", "install", pkg.Ident, "--offline"}
+		if _, err := runHabStreamed(ctx, "hab", args, env, sink, nil); err != nil {
+			return errors.Wrapf(err, "installing %s", pkg.Ident)
+		}
+		emit(sink, DependencyInstalled{Ident: pkg.Ident})
+	}
+
+	return nil
+}
+
+// loadServiceArgs builds the `hab svc load` argument list from a
+// resolved loadOpts. It is called by LoadServiceCtx, which
+// LoadService delegates to.
+func loadServiceArgs(svc habpkg.VersionedPackage, o *loadOpts) []string {
+	args := []string{"svc", "load", "--force", habpkg.Ident(svc), "--strategy", o.strategy}
+	if o.channel != "" {
+		args = append(args, "--channel", o.channel)
+	}
+	if o.topology != "" {
+		args = append(args, "--topology", o.topology)
+	}
+	if o.bindMode != "" {
+		args = append(args, "--binding-mode", o.bindMode)
+	}
+	for _, b := range o.binds {
+		args = append(args, "--bind", b)
+	}
+	if o.serviceGroup != "" {
+		args = append(args, "--group", o.serviceGroup)
+	}
+	if o.application != "" && o.environment != "" {
+		args = append(args, "--application", o.application, "--environment", o.environment)
+	}
+	if o.healthCheckInterval > 0 {
+		args = append(args, "--health-check-interval", strconv.Itoa(int(o.healthCheckInterval.Seconds())))
+	}
+	return args
+}
+
+// habEnv returns the base environment hab commands run with:
+// os.Environ() plus the standard non-interactive, non-colored output
+// settings.
+func habEnv() []string {
+	return append(os.Environ(), "HAB_NOCOLORING=true", "HAB_NONINTERACTIVE=true")
+}
+
+// runHabStreamed runs name with args and env under ctx, scanning its
+// combined stdout/stderr line-by-line (mirroring the Habitat
+// Terraform provisioner's use of go-linereader) and passing each line
+// through classify. Events returned by classify are forwarded to
+// sink. The process is killed if ctx is canceled before it exits.
+func runHabStreamed(ctx context.Context, name string, args []string, env []string, sink EventSink, classify func(string) Event) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = env
+
+	var combined bytes.Buffer
+	pr, pw := io.Pipe()
+	// Stdout and Stderr must share the exact same io.Writer value:
+	// os/exec spawns one copy goroutine per stream, and two distinct
+	// MultiWriters over the same *bytes.Buffer would let those
+	// goroutines race on it.
+	w := io.MultiWriter(&combined, pw)
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	lr := linereader.New(pr)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for line := range lr.Ch {
+			if classify == nil {
+				continue
+			}
+			if ev := classify(line); ev != nil {
+				emit(sink, ev)
+			}
+		}
+	}()
+
+	runErr := cmd.Run()
+	pw.Close() // nolint: errcheck
+	<-done
+
+	if runErr != nil {
+		return combined.String(), errors.Wrapf(runErr, "running %s %v", name, args)
+	}
+	return combined.String(), nil
+}
@@ -0,0 +1,148 @@
+package target
+
+import (
+	"context"
+	"testing"
+)
+
+// fakeSink is a minimal EventSink that records every event it
+// receives, in order.
+type fakeSink struct {
+	events []Event
+}
+
+func (f *fakeSink) Event(ev Event) {
+	f.events = append(f.events, ev)
+}
+
+func TestEmit(t *testing.T) {
+	sink := &fakeSink{}
+	emit(sink, ServiceLoaded{Ident: "core/pkgname"})
+
+	if len(sink.events) != 1 {
+		t.Fatalf("got %d events, want 1", len(sink.events))
+	}
+	if got, ok := sink.events[0].(ServiceLoaded); !ok || got.Ident != "core/pkgname" {
+		t.Errorf("got %#v, want ServiceLoaded{Ident: \"core/pkgname\"}", sink.events[0])
+	}
+
+	// emit must not panic when sink is nil.
+	emit(nil, ServiceLoaded{Ident: "core/pkgname"})
+}
+
+func TestHabInstallingRe(t *testing.T) {
+	tests := []struct {
+		line    string
+		matches bool
+		ident   string
+	}{
+		{"→ Installing core/pkgname", true, "core/pkgname"},
+		{"Installing core/pkgname/1.0.0/20200101000000", true, "core/pkgname/1.0.0/20200101000000"},
+		{"★ Installed core/pkgname", false, ""},
+		{"some unrelated line", false, ""},
+	}
+	for _, tt := range tests {
+		m := habInstallingRe.FindStringSubmatch(tt.line)
+		if tt.matches && m == nil {
+			t.Errorf("habInstallingRe did not match %q", tt.line)
+			continue
+		}
+		if !tt.matches && m != nil {
+			t.Errorf("habInstallingRe matched %q, want no match", tt.line)
+			continue
+		}
+		if tt.matches && m[1] != tt.ident {
+			t.Errorf("habInstallingRe(%q) ident = %q, want %q", tt.line, m[1], tt.ident)
+		}
+	}
+}
+
+func TestHabInstalledRe(t *testing.T) {
+	tests := []struct {
+		line    string
+		matches bool
+		ident   string
+	}{
+		{"★ Installed core/pkgname", true, "core/pkgname"},
+		{"Installed core/pkgname/1.0.0/20200101000000", true, "core/pkgname/1.0.0/20200101000000"},
+		{"→ Installing core/pkgname", false, ""},
+	}
+	for _, tt := range tests {
+		m := habInstalledRe.FindStringSubmatch(tt.line)
+		if tt.matches && m == nil {
+			t.Errorf("habInstalledRe did not match %q", tt.line)
+			continue
+		}
+		if !tt.matches && m != nil {
+			t.Errorf("habInstalledRe matched %q, want no match", tt.line)
+			continue
+		}
+		if tt.matches && m[1] != tt.ident {
+			t.Errorf("habInstalledRe(%q) ident = %q, want %q", tt.line, m[1], tt.ident)
+		}
+	}
+}
+
+func TestHabServiceLoadedRe(t *testing.T) {
+	tests := []struct {
+		line    string
+		matches bool
+	}{
+		{"The core/pkgname service was successfully loaded", true},
+		{"THE CORE/PKGNAME SERVICE WAS SUCCESSFULLY LOADED", true},
+		{"core/pkgname was successfully uploaded revision 1", false},
+		{"some unrelated line", false},
+	}
+	for _, tt := range tests {
+		got := habServiceLoadedRe.MatchString(tt.line)
+		if got != tt.matches {
+			t.Errorf("habServiceLoadedRe.MatchString(%q) = %v, want %v", tt.line, got, tt.matches)
+		}
+	}
+}
+
+func TestRunHabStreamed(t *testing.T) {
+	out, err := runHabStreamed(context.Background(), "echo", []string{"hello world"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("runHabStreamed returned error: %v", err)
+	}
+	if out != "hello world\n" {
+		t.Errorf("output = %q, want %q", out, "hello world\n")
+	}
+}
+
+func TestRunHabStreamedClassifiesLines(t *testing.T) {
+	sink := &fakeSink{}
+	classify := func(line string) Event {
+		if line == "hello" {
+			return ServiceLoaded{Ident: "core/pkgname"}
+		}
+		return nil
+	}
+
+	if _, err := runHabStreamed(context.Background(), "echo", []string{"hello"}, nil, sink, classify); err != nil {
+		t.Fatalf("runHabStreamed returned error: %v", err)
+	}
+
+	if len(sink.events) != 1 {
+		t.Fatalf("got %d events, want 1", len(sink.events))
+	}
+	if got, ok := sink.events[0].(ServiceLoaded); !ok || got.Ident != "core/pkgname" {
+		t.Errorf("got %#v, want ServiceLoaded{Ident: \"core/pkgname\"}", sink.events[0])
+	}
+}
+
+func TestRunHabStreamedCommandFailure(t *testing.T) {
+	if _, err := runHabStreamed(context.Background(), "false", nil, nil, nil, nil); err == nil {
+		t.Fatal("expected an error from a failing command, got nil")
+	}
+}
+
+func TestRunHabStreamedCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := runHabStreamed(ctx, "sleep", []string{"5"}, nil, nil, nil); err == nil {
+		t.Fatal("expected an error from a canceled context, got nil")
+	}
+}
@@ -0,0 +1,57 @@
+package target
+
+// Event is the common interface implemented by every event a HabCmd
+// Ctx method can emit through an EventSink.
+type Event interface {
+	isHabEvent()
+}
+
+// PackageResolved is emitted once a requested package has been
+// resolved to a concrete origin/name/version/release.
+type PackageResolved struct {
+	Ident string
+}
+
+// DownloadProgress is emitted periodically while a package artifact
+// is being downloaded.
+type DownloadProgress struct {
+	Bytes int64
+	Total int64
+}
+
+// DependencyInstalled is emitted each time a package (the requested
+// package or one of its dependencies) finishes installing.
+type DependencyInstalled struct {
+	Ident string
+}
+
+// ServiceLoaded is emitted once `hab svc load` reports the service as
+// loaded.
+type ServiceLoaded struct {
+	Ident string
+}
+
+// LicenseAccepted is emitted once the Habitat license has been
+// accepted as part of InstallHabCtx.
+type LicenseAccepted struct{}
+
+func (PackageResolved) isHabEvent()     {}
+func (DownloadProgress) isHabEvent()    {}
+func (DependencyInstalled) isHabEvent() {}
+func (ServiceLoaded) isHabEvent()       {}
+func (LicenseAccepted) isHabEvent()     {}
+
+// An EventSink receives progress events emitted by HabCmd's Ctx
+// methods. Implementations must not block for long, since Event is
+// called synchronously from the goroutine parsing hab's output.
+type EventSink interface {
+	Event(Event)
+}
+
+// emit sends ev to sink if sink is non-nil, so callers don't have to
+// nil-check at every call site.
+func emit(sink EventSink, ev Event) {
+	if sink != nil {
+		sink.Event(ev)
+	}
+}
@@ -0,0 +1,196 @@
+package target
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const offlineBundleManifestName = "manifest.json"
+
+// offlineManifest describes the contents of an offline bootstrap
+// bundle: every package it carries, in the dependency order they
+// must be installed in, along with the hart file and checksum used
+// to verify it before install.
+type offlineManifest struct {
+	Packages []offlineManifestPackage `json:"packages"`
+}
+
+type offlineManifestPackage struct {
+	// Ident is the fully-qualified origin/name/version/release of
+	// the package.
+	Ident string `json:"ident"`
+	// Hart is the file name of this package's .hart file inside the
+	// bundle's harts/ directory. It must not contain a path separator.
+	Hart string `json:"hart"`
+	// SHA256 is the expected hex-encoded SHA-256 checksum of Hart.
+	SHA256 string `json:"sha256"`
+}
+
+// LoadOfflineBundle unpacks the tarball at path -- which must contain
+// a manifest.json, a harts/ directory of .hart files, and a keys/
+// directory of origin public keys -- into /hab/cache/artifacts and
+// /hab/cache/keys, verifying every hart against the manifest's
+// checksum before staging it, and then installs each package with
+// `hab pkg install --offline` in the order the manifest lists them.
+// This backs the `chef-automate deploy load-offline-bundle` command.
+func (c *habCmd) LoadOfflineBundle(path string) error {
+	return c.LoadOfflineBundleCtx(context.Background(), path, nil)
+}
+
+// stageOfflineKeys copies every origin public key found in keysDir
+// into /hab/cache/keys. keysDir not existing is not an error, since a
+// bundle may rely on keys already present on the host.
+func stageOfflineKeys(keysDir string) error {
+	entries, err := os.ReadDir(keysDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "reading bundle keys directory")
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		src := filepath.Join(keysDir, e.Name())
+		dst := filepath.Join(habKeyCacheDir, e.Name())
+		if err := copyFile(src, dst, 0644); err != nil {
+			return errors.Wrapf(err, "installing origin key %s", e.Name())
+		}
+	}
+	return nil
+}
+
+func readOfflineManifest(path string) (*offlineManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	var m offlineManifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// verifySHA256 returns an error if the SHA-256 checksum of the file
+// at path does not match want (hex-encoded).
+func verifySHA256(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		return errors.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
+
+// extractTarGz extracts the gzip-compressed tarball at srcPath into
+// destDir.
+func extractTarGz(srcPath, destDir string) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrap(err, "opening gzip stream")
+	}
+	defer gz.Close() // nolint: errcheck
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := safeJoinExtractPath(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close() // nolint: errcheck
+				return err
+			}
+			out.Close() // nolint: errcheck
+		}
+	}
+}
+
+// safeJoinExtractPath joins name (a tar entry path, which may contain
+// attacker-controlled "../" components) onto destDir and verifies the
+// result is still contained within destDir, rejecting Zip-Slip-style
+// path traversal out of the extraction directory. It is shared by
+// extractTarGz and unpackHart.
+func safeJoinExtractPath(destDir, name string) (string, error) {
+	destDir = filepath.Clean(destDir)
+	prefix := destDir
+	if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix += string(os.PathSeparator)
+	}
+
+	target := filepath.Join(destDir, name)
+	if target != destDir && !strings.HasPrefix(target, prefix) {
+		return "", errors.Errorf("archive entry %q escapes destination directory", name)
+	}
+	return target, nil
+}
+
+// copyFile copies src to dst, creating dst with the given mode.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() // nolint: errcheck
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close() // nolint: errcheck
+
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,56 @@
+package target
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoinExtractPath(t *testing.T) {
+	destDir := "/hab/pkgs"
+
+	t.Run("well-behaved entry", func(t *testing.T) {
+		got, err := safeJoinExtractPath(destDir, "core/pkgname/1.0.0/20200101000000/MANIFEST")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := filepath.Join(destDir, "core/pkgname/1.0.0/20200101000000/MANIFEST")
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("rejects path traversal", func(t *testing.T) {
+		if _, err := safeJoinExtractPath(destDir, "../../../etc/cron.d/evil"); err == nil {
+			t.Fatal("expected an error for an entry escaping destDir, got nil")
+		}
+	})
+
+	t.Run("rejects traversal that cancels out to the root", func(t *testing.T) {
+		if _, err := safeJoinExtractPath(destDir, "../pkgs/../../etc/passwd"); err == nil {
+			t.Fatal("expected an error for an entry escaping destDir, got nil")
+		}
+	})
+}
+
+func TestVerifySHA256(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact")
+	content := []byte("hartifact contents")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("writing test file: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	want := hex.EncodeToString(sum[:])
+
+	if err := verifySHA256(path, want); err != nil {
+		t.Errorf("verifySHA256 with matching checksum returned error: %v", err)
+	}
+
+	if err := verifySHA256(path, "0000000000000000000000000000000000000000000000000000000000000000"); err == nil {
+		t.Fatal("expected a checksum mismatch error, got nil")
+	}
+}
@@ -0,0 +1,31 @@
+package target
+
+import "testing"
+
+func TestUpdateStrategy(t *testing.T) {
+	for _, strategy := range []string{"none", "at-once", "rolling", ""} {
+		o := &loadOpts{}
+		if err := UpdateStrategy(strategy, "stable")(o); err != nil {
+			t.Errorf("UpdateStrategy(%q, ...) returned error: %v", strategy, err)
+		}
+	}
+
+	o := &loadOpts{}
+	if err := UpdateStrategy("bogus", "stable")(o); err == nil {
+		t.Error("expected an error for an invalid update strategy, got nil")
+	}
+}
+
+func TestTopology(t *testing.T) {
+	for _, topology := range []string{"standalone", "leader", ""} {
+		o := &loadOpts{}
+		if err := Topology(topology)(o); err != nil {
+			t.Errorf("Topology(%q) returned error: %v", topology, err)
+		}
+	}
+
+	o := &loadOpts{}
+	if err := Topology("bogus")(o); err == nil {
+		t.Error("expected an error for an invalid topology, got nil")
+	}
+}